@@ -0,0 +1,64 @@
+package ldap
+
+import (
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func Factory(conf *logical.BackendConfig) (logical.Backend, error) {
+	b := Backend()
+	if conf == nil {
+		return b, nil
+	}
+	if err := b.Setup(conf); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func Backend() *backend {
+	var b backend
+	b.Backend = &framework.Backend{
+		Help: backendHelp,
+
+		PathsSpecial: &logical.Paths{
+			Root: []string{
+				"config",
+				"config/*",
+			},
+		},
+
+		Paths: []*framework.Path{
+			pathConfig(&b),
+			pathConfigReload(&b),
+			pathConfigTest(&b),
+			pathConfigStatus(&b),
+			pathGroups(&b),
+			pathLogin(&b),
+		},
+
+		AuthRenew: b.pathLoginRenew,
+	}
+	return &b
+}
+
+type backend struct {
+	*framework.Backend
+}
+
+// Setup runs once when the backend is mounted. It gives VAULT_LDAP_CONFIG_FILE
+// a chance to bootstrap the initial config before any request is served, for
+// immutable-infra deployments where writing to "config" post-unseal is
+// awkward.
+func (b *backend) Setup(conf *logical.BackendConfig) error {
+	if err := b.Backend.Setup(conf); err != nil {
+		return err
+	}
+	return b.bootstrapFromFile(&logical.Request{Storage: conf.StorageView})
+}
+
+const backendHelp = `
+The "ldap" credential provider allows authentication against an LDAP server,
+checking username and password (in the SASL EXTERNAL bind modes, only that
+the user's entry exists), and mapping group membership to policies.
+`
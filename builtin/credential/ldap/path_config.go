@@ -1,14 +1,18 @@
 package ldap
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/url"
 	"strings"
+	"sync"
+	"text/template"
 
-	"github.com/go-ldap/ldap"
+	"github.com/go-ldap/ldap/v3"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
@@ -21,6 +25,10 @@ func pathConfig(b *backend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: "ldap URL to connect to (default: ldap://127.0.0.1)",
 			},
+			"servers": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "JSON list of LDAP servers to try in order, each with its own url/certificate/starttls/insecure_tls overrides. Takes precedence over 'url' when set.",
+			},
 			"userdn": &framework.FieldSchema{
 				Type:        framework.TypeString,
 				Description: "LDAP domain to use for users (eg: ou=People,dc=example,dc=org)",
@@ -49,6 +57,34 @@ func pathConfig(b *backend) *framework.Path {
 				Type:        framework.TypeBool,
 				Description: "Issue a StartTLS command after establishing unencrypted connection (optional)",
 			},
+			"client_certificate": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Client certificate to present to the LDAP server, must be x509 PEM encoded (optional, required when bind_mode is sasl_external or sasl_external_then_search)",
+			},
+			"client_key": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Private key matching client_certificate, must be PEM encoded (optional, required when bind_mode is sasl_external or sasl_external_then_search)",
+			},
+			"bind_mode": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "How Vault authenticates to the LDAP server to perform user/group lookups: 'simple' (default, simple bind with the user's password) or 'sasl_external'/'sasl_external_then_search' (equivalent: SASL EXTERNAL bind using client_certificate/client_key, followed by the usual user/group search over the resulting connection). client_certificate/client_key authenticate Vault to the directory, not the end user, so the user/group search and its result still gate the login either way.",
+			},
+			"user_search_filter": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Go text/template, evaluated with .Username, used to build the user search filter in place of the fixed userattr=username construction (optional)",
+			},
+			"groupfilter": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Go text/template, evaluated with .Username and .UserDN, used to build the group membership search filter (default: '(&(objectClass=group)(member={{.UserDN}}))')",
+			},
+			"groupattr": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "LDAP attribute that holds the group name to be used as a Vault group (default: cn)",
+			},
+			"nested_group_search": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "Use the LDAP_MATCHING_RULE_IN_CHAIN OID to resolve nested group memberships in a single query (Active Directory only, optional)",
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -90,26 +126,43 @@ func (b *backend) pathConfigRead(
 
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"url":          cfg.Url,
-			"userdn":       cfg.UserDN,
-			"groupdn":      cfg.GroupDN,
-			"upndomain":    cfg.UPNDomain,
-			"userattr":     cfg.UserAttr,
-			"certificate":  cfg.Certificate,
-			"insecure_tls": cfg.InsecureTLS,
-			"starttls":     cfg.StartTLS,
+			"url":                 cfg.Url,
+			"servers":             cfg.Servers,
+			"userdn":              cfg.UserDN,
+			"groupdn":             cfg.GroupDN,
+			"upndomain":           cfg.UPNDomain,
+			"userattr":            cfg.UserAttr,
+			"certificate":         cfg.Certificate,
+			"insecure_tls":        cfg.InsecureTLS,
+			"starttls":            cfg.StartTLS,
+			"client_certificate":  cfg.ClientCertificate,
+			"bind_mode":           cfg.BindMode,
+			"user_search_filter":  cfg.UserSearchFilter,
+			"groupfilter":         cfg.GroupFilter,
+			"groupattr":           cfg.GroupAttr,
+			"nested_group_search": cfg.NestedGroupSearch,
 		},
 	}, nil
 }
 
-func (b *backend) pathConfigWrite(
-	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-
+// buildConfigEntryFromFieldData parses and validates a ConfigEntry out of
+// posted field data, without dialing any server or touching storage. The
+// returned *logical.Response is non-nil only when validation failed and
+// should be returned to the caller as-is.
+func buildConfigEntryFromFieldData(d *framework.FieldData) (*ConfigEntry, *logical.Response, error) {
 	cfg := &ConfigEntry{}
 	url := d.Get("url").(string)
 	if url != "" {
 		cfg.Url = strings.ToLower(url)
 	}
+	serversRaw := d.Get("servers").(string)
+	if serversRaw != "" {
+		var servers []*LDAPServer
+		if err := json.Unmarshal([]byte(serversRaw), &servers); err != nil {
+			return nil, logical.ErrorResponse(fmt.Sprintf("invalid servers: %v", err)), nil
+		}
+		cfg.Servers = servers
+	}
 	userattr := d.Get("userattr").(string)
 	if userattr != "" {
 		cfg.UserAttr = strings.ToLower(userattr)
@@ -138,16 +191,105 @@ func (b *backend) pathConfigWrite(
 	if startTLS {
 		cfg.StartTLS = startTLS
 	}
+	clientCertificate := d.Get("client_certificate").(string)
+	if clientCertificate != "" {
+		cfg.ClientCertificate = clientCertificate
+	}
+	clientKey := d.Get("client_key").(string)
+	if clientKey != "" {
+		cfg.ClientKey = clientKey
+	}
+	bindMode := d.Get("bind_mode").(string)
+	if bindMode != "" {
+		cfg.BindMode = bindMode
+	} else {
+		cfg.BindMode = bindModeSimple
+	}
+	userSearchFilter := d.Get("user_search_filter").(string)
+	if userSearchFilter != "" {
+		cfg.UserSearchFilter = userSearchFilter
+	}
+	groupFilter := d.Get("groupfilter").(string)
+	if groupFilter != "" {
+		cfg.GroupFilter = groupFilter
+	} else {
+		cfg.GroupFilter = defaultGroupFilter
+	}
+	groupAttr := d.Get("groupattr").(string)
+	if groupAttr != "" {
+		cfg.GroupAttr = groupAttr
+	} else {
+		cfg.GroupAttr = "cn"
+	}
+	cfg.NestedGroupSearch = d.Get("nested_group_search").(bool)
+
+	if err := cfg.Validate(); err != nil {
+		return nil, logical.ErrorResponse(err.Error()), nil
+	}
+
+	return cfg, nil, nil
+}
+
+// Validate checks the fields that don't require dialing a server: that the
+// bind mode is recognized and has the credentials it needs, and that the
+// group/user search filter templates parse and reference a non-empty
+// groupattr. It's shared by config writes, config/test, and the YAML
+// bootstrap path so all three enforce the same rules.
+func (c *ConfigEntry) Validate() error {
+	switch c.BindMode {
+	case bindModeSimple, bindModeSASLExternal, bindModeSASLExternalThenSearch:
+	default:
+		return fmt.Errorf("invalid bind_mode %q", c.BindMode)
+	}
+	if c.BindMode != bindModeSimple && (c.ClientCertificate == "" || c.ClientKey == "") {
+		return fmt.Errorf("bind_mode %q requires client_certificate and client_key", c.BindMode)
+	}
+	if c.UserSearchFilter != "" {
+		if _, err := template.New("user_search_filter").Parse(c.UserSearchFilter); err != nil {
+			return fmt.Errorf("invalid user_search_filter: %v", err)
+		}
+	}
+	if _, err := template.New("groupfilter").Parse(c.GroupFilter); err != nil {
+		return fmt.Errorf("invalid groupfilter: %v", err)
+	}
+	if c.GroupAttr == "" {
+		return fmt.Errorf("groupattr must not be empty")
+	}
+	return nil
+}
+
+func (b *backend) pathConfigWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 
-	// Try to connect to the LDAP server, to validate the URL configuration
-	// We can also check the URL at this stage, as anything else would probably
-	// require authentication.
-	conn, cerr := cfg.DialLDAP()
+	cfg, resp, err := buildConfigEntryFromFieldData(d)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	// Try to connect to the LDAP servers, to validate the configuration. At
+	// least one server must accept a connection; servers are tried in order
+	// up to the first that does, and any of those that failed are reported
+	// back so the operator can tell which ones are unreachable.
+	conn, failures, cerr := cfg.DialLDAP(b)
 	if cerr != nil {
-		return logical.ErrorResponse(cerr.Error()), nil
+		errResp := logical.ErrorResponse(cerr.Error())
+		if len(failures) > 0 {
+			errResp.Data["server_errors"] = failures
+		}
+		return errResp, nil
 	}
 	conn.Close()
 
+	existing, err := b.Config(req)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		cfg.Version = existing.Version + 1
+	} else {
+		cfg.Version = 1
+	}
+
 	entry, err := logical.StorageEntryJSON("config", cfg)
 	if err != nil {
 		return nil, err
@@ -155,12 +297,41 @@ func (b *backend) pathConfigWrite(
 	if err := req.Storage.Put(entry); err != nil {
 		return nil, err
 	}
+	setLiveConfig(cfg)
+	evictStaleConns(b, cfg.Version)
 
-	return nil, nil
+	var writeResp *logical.Response
+	if len(failures) > 0 {
+		writeResp = &logical.Response{
+			Data: map[string]interface{}{
+				"server_errors": failures,
+			},
+		}
+	}
+	return writeResp, nil
+}
+
+// LDAPServer describes a single LDAP server to try, along with the TLS
+// overrides that apply only to that server. This lets an operator mix, for
+// example, an in-DC primary with a DR replica signed by a different CA.
+type LDAPServer struct {
+	Url         string `json:"url"`
+	Certificate string `json:"certificate"`
+	InsecureTLS bool   `json:"insecure_tls"`
+	StartTLS    bool   `json:"starttls"`
 }
 
+// Bind modes control how Vault authenticates to the LDAP server before
+// performing the user/group lookups needed to complete a login.
+const (
+	bindModeSimple                 = "simple"
+	bindModeSASLExternal           = "sasl_external"
+	bindModeSASLExternalThenSearch = "sasl_external_then_search"
+)
+
 type ConfigEntry struct {
 	Url         string
+	Servers     []*LDAPServer
 	UserDN      string
 	GroupDN     string
 	UPNDomain   string
@@ -168,18 +339,127 @@ type ConfigEntry struct {
 	Certificate string
 	InsecureTLS bool
 	StartTLS    bool
+
+	// ClientCertificate and ClientKey, when set, let Vault present an X.509
+	// client certificate to the LDAP server (SASL EXTERNAL bind) instead of
+	// a simple bind with the user's password. In the SASL EXTERNAL bind
+	// modes the user's password is not checked.
+	ClientCertificate string
+	ClientKey         string
+	BindMode          string
+
+	// UserSearchFilter, when set, replaces the fixed userattr=username
+	// construction used to find the user's entry.
+	UserSearchFilter string
+
+	// GroupFilter is a text/template, evaluated with .Username and .UserDN,
+	// used to search for the groups a user belongs to.
+	GroupFilter string
+
+	// GroupAttr is the attribute on a group entry that holds its name.
+	GroupAttr string
+
+	// NestedGroupSearch enables AD's LDAP_MATCHING_RULE_IN_CHAIN OID so that
+	// nested group memberships are resolved in a single query.
+	NestedGroupSearch bool
+
+	// Version is bumped on every successful write and used to key the
+	// connection pool so that a config change doesn't hand out a pooled
+	// connection dialed against stale TLS settings.
+	Version uint64
+}
+
+// defaultGroupFilter matches every group that lists the user's DN as a
+// direct member.
+const defaultGroupFilter = `(&(objectClass=group)(member={{.UserDN}}))`
+
+// nestedGroupMatchingRuleOID is the AD-specific OID that, used as a
+// transitive-closure filter rule, resolves nested group memberships in one
+// query instead of requiring a walk up the group hierarchy.
+const nestedGroupMatchingRuleOID = "1.2.840.113556.1.4.1941"
+
+// groupFilterParams is the data made available to the groupfilter and
+// user_search_filter templates. Both fields are pre-escaped with
+// ldap.EscapeFilter before the template is executed, since these templates
+// are reachable, unauthenticated, from login/<username>.
+type groupFilterParams struct {
+	Username string
+	UserDN   string
 }
 
-func (c *ConfigEntry) GetTLSConfig(host string) (*tls.Config, error) {
+// RenderGroupFilter evaluates the configured (or default) groupfilter
+// template for the given user, optionally rewriting the member attribute to
+// use the nested-group matching rule OID.
+func (c *ConfigEntry) RenderGroupFilter(username, userDN string) (string, error) {
+	filter := c.GroupFilter
+	if filter == "" {
+		filter = defaultGroupFilter
+	}
+	if c.NestedGroupSearch {
+		filter = strings.Replace(filter, "member=", fmt.Sprintf("member:%s:=", nestedGroupMatchingRuleOID), 1)
+	}
+
+	tmpl, err := template.New("groupfilter").Parse(filter)
+	if err != nil {
+		return "", fmt.Errorf("invalid groupfilter: %v", err)
+	}
+	var buf bytes.Buffer
+	params := groupFilterParams{
+		Username: ldap.EscapeFilter(username),
+		UserDN:   ldap.EscapeFilter(userDN),
+	}
+	if err := tmpl.Execute(&buf, params); err != nil {
+		return "", fmt.Errorf("error evaluating groupfilter: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// RenderUserSearchFilter evaluates the user_search_filter template, if one
+// is configured, for the given username.
+func (c *ConfigEntry) RenderUserSearchFilter(username string) (string, error) {
+	if c.UserSearchFilter == "" {
+		return "", nil
+	}
+	tmpl, err := template.New("user_search_filter").Parse(c.UserSearchFilter)
+	if err != nil {
+		return "", fmt.Errorf("invalid user_search_filter: %v", err)
+	}
+	var buf bytes.Buffer
+	params := groupFilterParams{Username: ldap.EscapeFilter(username)}
+	if err := tmpl.Execute(&buf, params); err != nil {
+		return "", fmt.Errorf("error evaluating user_search_filter: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// ldapServers returns the list of servers to try, in order. When no explicit
+// server list has been configured it falls back to the single legacy
+// Url/Certificate/InsecureTLS/StartTLS fields so existing configs keep
+// working unchanged.
+func (c *ConfigEntry) ldapServers() []*LDAPServer {
+	if len(c.Servers) > 0 {
+		return c.Servers
+	}
+	return []*LDAPServer{
+		{
+			Url:         c.Url,
+			Certificate: c.Certificate,
+			InsecureTLS: c.InsecureTLS,
+			StartTLS:    c.StartTLS,
+		},
+	}
+}
+
+func (server *LDAPServer) GetTLSConfig(host string) (*tls.Config, error) {
 	tlsConfig := &tls.Config{
 		ServerName: host,
 	}
-	if c.InsecureTLS {
+	if server.InsecureTLS {
 		tlsConfig.InsecureSkipVerify = true
 	}
-	if c.Certificate != "" {
+	if server.Certificate != "" {
 		caPool := x509.NewCertPool()
-		ok := caPool.AppendCertsFromPEM([]byte(c.Certificate))
+		ok := caPool.AppendCertsFromPEM([]byte(server.Certificate))
 		if !ok {
 			return nil, fmt.Errorf("could not append CA certificate")
 		}
@@ -188,9 +468,133 @@ func (c *ConfigEntry) GetTLSConfig(host string) (*tls.Config, error) {
 	return tlsConfig, nil
 }
 
-func (c *ConfigEntry) DialLDAP() (*ldap.Conn, error) {
+// GetTLSConfig builds the TLS config for a connection to server, layering
+// the config-wide client certificate (used for SASL EXTERNAL binds) on top
+// of the server's own CA/insecure_tls overrides.
+func (c *ConfigEntry) GetTLSConfig(host string, server *LDAPServer) (*tls.Config, error) {
+	tlsConfig, err := server.GetTLSConfig(host)
+	if err != nil {
+		return nil, err
+	}
+	if c.ClientCertificate != "" {
+		cert, err := tls.X509KeyPair([]byte(c.ClientCertificate), []byte(c.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// connPoolKey namespaces pooled connections by backend instance as well as
+// config version and server URL, so that two mounts of this backend pointed
+// at the same LDAP server (a common case: both commonly land on version 1 on
+// their first write) never hand each other's connections back out. Each
+// mount gets its own *backend, so its pointer is a cheap, unique namespace.
+type connPoolKey struct {
+	backend *backend
+	version uint64
+	url     string
+}
+
+// connPool caches live connections keyed by connPoolKey so that repeated
+// Login calls don't pay for a fresh TCP+TLS handshake every time. Entries
+// are health-checked before being handed back out and evicted when that
+// check fails.
+var (
+	connPoolMu sync.Mutex
+	connPool   = map[connPoolKey]*ldap.Conn{}
+)
+
+func poolKey(b *backend, version uint64, server *LDAPServer) connPoolKey {
+	return connPoolKey{backend: b, version: version, url: server.Url}
+}
 
-	u, err := url.Parse(c.Url)
+func getPooledConn(b *backend, c *ConfigEntry, server *LDAPServer) *ldap.Conn {
+	connPoolMu.Lock()
+	defer connPoolMu.Unlock()
+
+	key := poolKey(b, c.Version, server)
+	conn, ok := connPool[key]
+	if !ok {
+		return nil
+	}
+	if !connIsHealthy(c, conn) {
+		conn.Close()
+		delete(connPool, key)
+		return nil
+	}
+	return conn
+}
+
+func putPooledConn(b *backend, version uint64, server *LDAPServer, conn *ldap.Conn) {
+	connPoolMu.Lock()
+	defer connPoolMu.Unlock()
+	connPool[poolKey(b, version, server)] = conn
+}
+
+// evictStaleConns closes and removes every pooled connection belonging to b
+// whose version is older than keepVersion. It's called after every version
+// bump (config write or config/reload) so a config change doesn't leak the
+// previous version's open sockets forever.
+func evictStaleConns(b *backend, keepVersion uint64) {
+	connPoolMu.Lock()
+	defer connPoolMu.Unlock()
+
+	for key, conn := range connPool {
+		if key.backend == b && key.version < keepVersion {
+			conn.Close()
+			delete(connPool, key)
+		}
+	}
+}
+
+// connIsHealthy confirms a pooled connection is still usable before it's
+// handed back to a caller. A SASL EXTERNAL bound connection is rebound via
+// ExternalBind so the health check doesn't strip its authenticated identity;
+// otherwise a cheap anonymous rebind is used.
+func connIsHealthy(c *ConfigEntry, conn *ldap.Conn) bool {
+	var err error
+	if c.BindMode == bindModeSASLExternal || c.BindMode == bindModeSASLExternalThenSearch {
+		err = conn.ExternalBind()
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+// DialLDAP iterates over the configured servers, in order, returning the
+// first connection that succeeds. It returns the set of per-server errors
+// encountered along the way (even on overall success) so callers can
+// surface which servers are currently unreachable. b namespaces the
+// connection pool so that two mounts of this backend don't share
+// connections even when pointed at the same server.
+func (c *ConfigEntry) DialLDAP(b *backend) (*ldap.Conn, map[string]string, error) {
+	var servers = c.ldapServers()
+	failures := map[string]string{}
+
+	for _, server := range servers {
+		if conn := getPooledConn(b, c, server); conn != nil {
+			return conn, failures, nil
+		}
+
+		conn, err := c.dialLDAPServer(server)
+		if err != nil {
+			failures[server.Url] = err.Error()
+			continue
+		}
+		putPooledConn(b, c.Version, server, conn)
+		return conn, failures, nil
+	}
+
+	return nil, failures, fmt.Errorf("no LDAP server could be reached, tried %d server(s)", len(servers))
+}
+
+func (c *ConfigEntry) dialLDAPServer(server *LDAPServer) (*ldap.Conn, error) {
+	u, err := url.Parse(server.Url)
 	if err != nil {
 		return nil, err
 	}
@@ -206,9 +610,10 @@ func (c *ConfigEntry) DialLDAP() (*ldap.Conn, error) {
 			port = "389"
 		}
 		conn, err = ldap.Dial("tcp", host+":"+port)
-		if c.StartTLS {
-			tlsConfig, err := c.GetTLSConfig(host)
-			if err != nil {
+		if server.StartTLS {
+			tlsConfig, terr := c.GetTLSConfig(host, server)
+			if terr != nil {
+				err = terr
 				break
 			}
 			err = conn.StartTLS(tlsConfig)
@@ -217,9 +622,9 @@ func (c *ConfigEntry) DialLDAP() (*ldap.Conn, error) {
 		if port == "" {
 			port = "636"
 		}
-		tlsConfig, err := c.GetTLSConfig(host)
-		if err != nil {
-			break
+		tlsConfig, terr := c.GetTLSConfig(host, server)
+		if terr != nil {
+			return nil, terr
 		}
 		conn, err = ldap.DialTLS("tcp", host+":"+port, tlsConfig)
 	default:
@@ -229,12 +634,21 @@ func (c *ConfigEntry) DialLDAP() (*ldap.Conn, error) {
 		return nil, fmt.Errorf("cannot connect to LDAP: %v", err)
 	}
 
+	if c.BindMode == bindModeSASLExternal || c.BindMode == bindModeSASLExternalThenSearch {
+		if err := conn.ExternalBind(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("SASL EXTERNAL bind failed: %v", err)
+		}
+	}
+
 	return conn, nil
 }
 
 func (c *ConfigEntry) SetDefaults() {
 	c.Url = "ldap://127.0.0.1"
 	c.UserAttr = "cn"
+	c.GroupFilter = defaultGroupFilter
+	c.GroupAttr = "cn"
 }
 
 const pathConfigHelpSyn = `
@@ -248,4 +662,27 @@ basic information of the schema of that server.
 The LDAP URL can use either the "ldap://" or "ldaps://" schema. In the former
 case, an unencrypted connection will be done, with default port 389; in the latter
 case, a SSL connection will be done, with default port 636.
+
+Multiple servers can be configured via "servers", a JSON list of objects each
+with their own url/certificate/starttls/insecure_tls settings. Vault tries
+each server in order and uses the first one that accepts a connection; this
+allows an in-DC primary to be paired with a DR replica that presents a
+different certificate.
+
+By default Vault performs a simple bind with the user's password. Setting
+"bind_mode" to "sasl_external" (or, equivalently, "sasl_external_then_search")
+makes Vault present the "client_certificate"/"client_key" pair to the server
+to authenticate itself via SASL EXTERNAL, instead of storing a service-account
+password; Vault still searches the directory over that connection and
+requires the user to be found before granting a login, since the client
+certificate authenticates Vault to the directory, not the end user.
+
+Group membership is found by evaluating "groupfilter", a text/template
+rendered with .Username and .UserDN, against the directory (default:
+"(&(objectClass=group)(member={{.UserDN}}))"); the group name is read from
+the "groupattr" attribute of each match (default: cn). Setting
+"nested_group_search" resolves nested group memberships in a single query
+using Active Directory's LDAP_MATCHING_RULE_IN_CHAIN OID. "user_search_filter"
+similarly replaces the fixed userattr=username construction used to locate
+the user's own entry.
 `
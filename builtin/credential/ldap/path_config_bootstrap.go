@@ -0,0 +1,136 @@
+package ldap
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/hashicorp/vault/logical"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configFileEnvVar names the environment variable that points at a YAML
+// file Vault can use to bootstrap its initial LDAP config, for immutable-
+// infra deployments where writing to the "config" endpoint post-unseal is
+// awkward.
+const configFileEnvVar = "VAULT_LDAP_CONFIG_FILE"
+
+// yamlConfig mirrors the "defaults:"/"servers:" shape used elsewhere for
+// LDAP connection bootstrap files, so operators can share one config format
+// across tooling that already talks to the same directory.
+type yamlConfig struct {
+	Defaults yamlConfigDefaults `yaml:"defaults"`
+	Servers  []yamlConfigServer `yaml:"servers"`
+}
+
+type yamlConfigDefaults struct {
+	UserDN            string `yaml:"userdn"`
+	GroupDN           string `yaml:"groupdn"`
+	UPNDomain         string `yaml:"upndomain"`
+	UserAttr          string `yaml:"userattr"`
+	BindMode          string `yaml:"bind_mode"`
+	ClientCertificate string `yaml:"client_certificate"`
+	ClientKey         string `yaml:"client_key"`
+	UserSearchFilter  string `yaml:"user_search_filter"`
+	GroupFilter       string `yaml:"groupfilter"`
+	GroupAttr         string `yaml:"groupattr"`
+	NestedGroupSearch bool   `yaml:"nested_group_search"`
+}
+
+type yamlConfigServer struct {
+	Url         string `yaml:"url"`
+	Certificate string `yaml:"certificate"`
+	InsecureTLS bool   `yaml:"insecure_tls"`
+	StartTLS    bool   `yaml:"starttls"`
+}
+
+func (y *yamlConfig) toConfigEntry() *ConfigEntry {
+	cfg := &ConfigEntry{}
+	cfg.SetDefaults()
+
+	cfg.UserDN = y.Defaults.UserDN
+	cfg.GroupDN = y.Defaults.GroupDN
+	cfg.UPNDomain = y.Defaults.UPNDomain
+	if y.Defaults.BindMode != "" {
+		cfg.BindMode = y.Defaults.BindMode
+	} else {
+		cfg.BindMode = bindModeSimple
+	}
+	cfg.ClientCertificate = y.Defaults.ClientCertificate
+	cfg.ClientKey = y.Defaults.ClientKey
+	cfg.UserSearchFilter = y.Defaults.UserSearchFilter
+	cfg.NestedGroupSearch = y.Defaults.NestedGroupSearch
+	if y.Defaults.UserAttr != "" {
+		cfg.UserAttr = y.Defaults.UserAttr
+	}
+	if y.Defaults.GroupFilter != "" {
+		cfg.GroupFilter = y.Defaults.GroupFilter
+	}
+	if y.Defaults.GroupAttr != "" {
+		cfg.GroupAttr = y.Defaults.GroupAttr
+	}
+	for _, s := range y.Servers {
+		cfg.Servers = append(cfg.Servers, &LDAPServer{
+			Url:         s.Url,
+			Certificate: s.Certificate,
+			InsecureTLS: s.InsecureTLS,
+			StartTLS:    s.StartTLS,
+		})
+	}
+	return cfg
+}
+
+// bootstrapFromFile loads the initial LDAP config from the YAML file named
+// by VAULT_LDAP_CONFIG_FILE, if set and no config is already stored. It runs
+// the same validation pathConfigWrite does (including dialing the configured
+// servers in order until one accepts a connection) before persisting, so a
+// malformed bootstrap file fails
+// closed rather than leaving the backend half-configured.
+func (b *backend) bootstrapFromFile(req *logical.Request) error {
+	path := os.Getenv(configFileEnvVar)
+	if path == "" {
+		return nil
+	}
+
+	existing, err := b.Config(req)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var y yamlConfig
+	if err := yaml.Unmarshal(raw, &y); err != nil {
+		return err
+	}
+	cfg := y.toConfigEntry()
+
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	conn, _, err := cfg.DialLDAP(b)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+
+	cfg.Version = 1
+	entry, err := logical.StorageEntryJSON("config", cfg)
+	if err != nil {
+		return err
+	}
+	if err := req.Storage.Put(entry); err != nil {
+		return err
+	}
+	setLiveConfig(cfg)
+
+	return nil
+}
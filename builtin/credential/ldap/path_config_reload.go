@@ -0,0 +1,293 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// liveConfig is the config most recently accepted by pathConfigWrite (at
+// least one server reachable), pathConfigReloadWrite (every server
+// reachable) or the YAML bootstrap. It is shared by every mount of this
+// backend. Logins and the config endpoints read the stored config, not
+// liveConfig.
+var (
+	liveConfigMu sync.RWMutex
+	liveConfig   *ConfigEntry
+)
+
+func setLiveConfig(cfg *ConfigEntry) {
+	liveConfigMu.Lock()
+	defer liveConfigMu.Unlock()
+	liveConfig = cfg
+}
+
+func getLiveConfig() *ConfigEntry {
+	liveConfigMu.RLock()
+	defer liveConfigMu.RUnlock()
+	return liveConfig
+}
+
+func pathConfigReload(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: `config/reload`,
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.WriteOperation: b.pathConfigReloadWrite,
+		},
+
+		HelpSynopsis:    pathConfigReloadHelpSyn,
+		HelpDescription: pathConfigReloadHelpDesc,
+	}
+}
+
+func (b *backend) pathConfigReloadWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	cfg, err := b.Config(req)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return logical.ErrorResponse("no config to reload"), nil
+	}
+
+	// Bump the version so a reload always redials fresh connections instead
+	// of handing back whatever happens to be sitting in the pool.
+	cfg.Version++
+
+	results, ok := redialAll(cfg)
+	if !ok {
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"reloaded":      false,
+				"server_errors": results,
+			},
+		}, nil
+	}
+
+	// Persist the bumped version so a later pathConfigWrite, which derives
+	// its own new version from storage, can't mint the same version number
+	// this reload just did and collide with it in the connection pool.
+	entry, err := logical.StorageEntryJSON("config", cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(entry); err != nil {
+		return nil, err
+	}
+
+	setLiveConfig(cfg)
+	evictStaleConns(b, cfg.Version)
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"reloaded":      true,
+			"server_errors": results,
+		},
+	}, nil
+}
+
+func pathConfigTest(b *backend) *framework.Path {
+	p := pathConfig(b)
+	p.Pattern = `config/test`
+	p.Callbacks = map[logical.Operation]framework.OperationFunc{
+		logical.WriteOperation: b.pathConfigTestWrite,
+	}
+	p.HelpSynopsis = pathConfigTestHelpSyn
+	p.HelpDescription = pathConfigTestHelpDesc
+	return p
+}
+
+func (b *backend) pathConfigTestWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	// Build the candidate config the same way pathConfigWrite does, but
+	// never persist it or swap it into the live config.
+	cfg, resp, err := buildConfigEntryFromFieldData(d)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	results, ok := redialAll(cfg)
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"valid":         ok,
+			"server_errors": results,
+		},
+	}, nil
+}
+
+func pathConfigStatus(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: `config/status`,
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathConfigStatusRead,
+		},
+
+		HelpSynopsis:    pathConfigStatusHelpSyn,
+		HelpDescription: pathConfigStatusHelpDesc,
+	}
+}
+
+func (b *backend) pathConfigStatusRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	cfg, err := b.Config(req)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, nil
+	}
+
+	servers := map[string]interface{}{}
+	for _, server := range cfg.ldapServers() {
+		servers[server.Url] = serverStatus(cfg, server)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"servers": servers,
+		},
+	}, nil
+}
+
+// serverStatus reports reachability and, for ldaps:// servers, the
+// negotiated protocol version, cipher suite, and the peer certificate's
+// subject and expiry, without disturbing the connection pool. StartTLS
+// servers are probed by actually performing the StartTLS upgrade (via
+// dialLDAPServer) rather than a raw TLS dial, since a StartTLS port speaks
+// plaintext LDAP until the upgrade is negotiated.
+func serverStatus(c *ConfigEntry, server *LDAPServer) map[string]interface{} {
+	u, err := url.Parse(server.Url)
+	if err != nil {
+		return map[string]interface{}{"reachable": false, "error": err.Error()}
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		host = u.Host
+		if u.Scheme == "ldaps" {
+			port = "636"
+		} else {
+			port = "389"
+		}
+	}
+
+	if server.StartTLS {
+		conn, err := c.dialLDAPServer(server)
+		if err != nil {
+			return map[string]interface{}{"reachable": false, "error": err.Error()}
+		}
+		conn.Close()
+		return map[string]interface{}{"reachable": true}
+	}
+
+	if u.Scheme != "ldaps" {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), 5*time.Second)
+		if err != nil {
+			return map[string]interface{}{"reachable": false, "error": err.Error()}
+		}
+		conn.Close()
+		return map[string]interface{}{"reachable": true}
+	}
+
+	tlsConfig, err := c.GetTLSConfig(host, server)
+	if err != nil {
+		return map[string]interface{}{"reachable": false, "error": err.Error()}
+	}
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, port), tlsConfig)
+	if err != nil {
+		return map[string]interface{}{"reachable": false, "error": err.Error()}
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	status := map[string]interface{}{
+		"reachable":    true,
+		"tls_version":  tlsVersionName(state.Version),
+		"cipher_suite": tls.CipherSuiteName(state.CipherSuite),
+	}
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		status["certificate_subject"] = cert.Subject.String()
+		status["certificate_expiry"] = cert.NotAfter.Format(time.RFC3339)
+	}
+	return status
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("0x%04x", version)
+	}
+}
+
+// redialAll tries to dial every configured server, bypassing the connection
+// pool, and returns the per-server errors encountered along with whether
+// every server succeeded.
+func redialAll(cfg *ConfigEntry) (map[string]string, bool) {
+	results := map[string]string{}
+	ok := true
+	for _, server := range cfg.ldapServers() {
+		conn, err := cfg.dialLDAPServer(server)
+		if err != nil {
+			results[server.Url] = err.Error()
+			ok = false
+			continue
+		}
+		conn.Close()
+		results[server.Url] = "ok"
+	}
+	return results, ok
+}
+
+const pathConfigReloadHelpSyn = `
+Redial every configured LDAP server and, if all succeed, drop pooled
+connections so later logins dial fresh ones.
+`
+
+const pathConfigReloadHelpDesc = `
+This endpoint reparses the stored config and redials each configured server,
+bypassing the connection pool. If every server accepts a connection, the
+config's version is bumped and stored, and pooled connections from earlier
+versions are closed; otherwise nothing is changed. Either way the response
+reports which servers failed with which error. Logins always use the stored
+config, so a config write takes effect without a reload.
+`
+
+const pathConfigTestHelpSyn = `
+Validate a posted config without persisting it.
+`
+
+const pathConfigTestHelpDesc = `
+This endpoint accepts the same fields as "config" but never writes to
+storage or affects the live config; it dials each server described by the
+posted body and reports which ones succeeded or failed.
+`
+
+const pathConfigStatusHelpSyn = `
+Report per-server reachability and TLS details for the stored config.
+`
+
+const pathConfigStatusHelpDesc = `
+This endpoint connects to each configured server and reports whether it is
+reachable, along with, for ldaps:// servers, the negotiated TLS version,
+cipher suite, and the peer certificate's subject and expiry. StartTLS servers
+report reachability only.
+`
@@ -0,0 +1,126 @@
+package ldap
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// splitPolicies turns a comma-separated policies field into a clean list,
+// dropping empty entries left behind by stray commas or whitespace.
+func splitPolicies(raw string) []string {
+	var policies []string
+	for _, p := range strings.Split(raw, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			policies = append(policies, p)
+		}
+	}
+	return policies
+}
+
+func pathGroups(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: `groups/(?P<name>.+)`,
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the LDAP group.",
+			},
+			"policies": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Comma-separated list of policies associated to this group.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathGroupsRead,
+			logical.WriteOperation:  b.pathGroupsWrite,
+			logical.DeleteOperation: b.pathGroupsDelete,
+		},
+
+		HelpSynopsis:    pathGroupsHelpSyn,
+		HelpDescription: pathGroupsHelpDesc,
+	}
+}
+
+// GroupEntry maps an LDAP group name to the Vault policies granted to any
+// user found to be a member of that group.
+type GroupEntry struct {
+	Policies []string
+}
+
+func (b *backend) Group(storage logical.Storage, name string) (*GroupEntry, error) {
+	entry, err := storage.Get(fmt.Sprintf("group/%s", name))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	var result GroupEntry
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (b *backend) pathGroupsDelete(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	if err := req.Storage.Delete(fmt.Sprintf("group/%s", d.Get("name").(string))); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (b *backend) pathGroupsRead(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	group, err := b.Group(req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"policies": group.Policies,
+		},
+	}, nil
+}
+
+func (b *backend) pathGroupsWrite(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	group := &GroupEntry{
+		Policies: splitPolicies(d.Get("policies").(string)),
+	}
+
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("group/%s", d.Get("name").(string)), group)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+const pathGroupsHelpSyn = `
+Manage the policies granted to members of LDAP groups.
+`
+
+const pathGroupsHelpDesc = `
+This endpoint allows you to create, read, update, and delete configuration
+for LDAP groups, and associate policies to them. Membership in a configured
+group is not required to log in; a user in no configured group gets no
+policies from groups.
+
+Deleting a group will not revoke auth for prior authenticated users.
+`
@@ -0,0 +1,235 @@
+package ldap
+
+import (
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathLogin(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: `login/(?P<username>.+)`,
+		Fields: map[string]*framework.FieldSchema{
+			"username": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "LDAP username",
+			},
+			"password": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Password for this user",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathLoginUpdate,
+		},
+
+		HelpSynopsis:    pathLoginHelpSyn,
+		HelpDescription: pathLoginHelpDesc,
+	}
+}
+
+func (b *backend) pathLoginUpdate(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	username := d.Get("username").(string)
+	password := d.Get("password").(string)
+
+	policies, resp, groupNames, err := b.Login(req, username, password)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	return &logical.Response{
+		Auth: &logical.Auth{
+			Policies: policies,
+			Metadata: map[string]string{
+				"username": username,
+				"groups":   fmt.Sprintf("%v", groupNames),
+			},
+			InternalData: map[string]interface{}{
+				"password": password,
+			},
+			DisplayName: username,
+		},
+	}, nil
+}
+
+func (b *backend) pathLoginRenew(
+	req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+
+	username := req.Auth.Metadata["username"]
+	password := req.Auth.InternalData["password"].(string)
+
+	policies, resp, _, err := b.Login(req, username, password)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+	if !policyutilEqual(policies, req.Auth.Policies) {
+		return nil, fmt.Errorf("policies no longer match")
+	}
+
+	return &logical.Response{Auth: req.Auth}, nil
+}
+
+// policyutilEqual reports whether two policy lists contain the same set of
+// policies, ignoring order.
+func policyutilEqual(a, b []string) bool {
+	setA := make(map[string]bool, len(a))
+	for _, p := range a {
+		setA[p] = true
+	}
+	setB := make(map[string]bool, len(b))
+	for _, p := range b {
+		if !setA[p] {
+			return false
+		}
+		setB[p] = true
+	}
+	return len(setA) == len(setB)
+}
+
+// Login binds to the configured LDAP server(s), locates the user's entry and
+// the groups it's a member of, and maps those groups to Vault policies. It
+// returns the resulting policy set, the resolved group names, and, when
+// authentication itself fails, a *logical.Response suitable for returning to
+// the caller as-is.
+func (b *backend) Login(req *logical.Request, username, password string) ([]string, *logical.Response, []string, error) {
+	cfg, err := b.Config(req)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if cfg == nil {
+		return nil, logical.ErrorResponse("ldap backend not configured"), nil, nil
+	}
+
+	// conn comes from the shared pool (see DialLDAP); it's intentionally not
+	// closed here; connIsHealthy() rebinds and validates it before it's
+	// handed out again, and closing it on every login would defeat pooling.
+	conn, _, err := cfg.DialLDAP(b)
+	if err != nil {
+		return nil, logical.ErrorResponse(err.Error()), nil, nil
+	}
+
+	userDN, err := b.findUserDN(cfg, conn, username)
+	if err != nil {
+		return nil, logical.ErrorResponse(err.Error()), nil, nil
+	}
+
+	// A plain "simple" bind is the only mode where Vault still checks the
+	// supplied password itself; the SASL EXTERNAL modes have already
+	// authenticated the connection via the client certificate and only use
+	// the username to locate the entry to search from.
+	if cfg.BindMode == bindModeSimple {
+		if err := conn.Bind(userDN, password); err != nil {
+			return nil, logical.ErrorResponse("ldap bind failed"), nil, nil
+		}
+	}
+
+	groupNames, err := b.findGroupNames(cfg, conn, username, userDN)
+	if err != nil {
+		return nil, logical.ErrorResponse(err.Error()), nil, nil
+	}
+
+	policies, err := b.groupPolicies(req.Storage, groupNames)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return policies, nil, groupNames, nil
+}
+
+// findUserDN locates the DN of the entry for username, using
+// user_search_filter when configured and falling back to the fixed
+// userattr=username construction otherwise.
+func (b *backend) findUserDN(cfg *ConfigEntry, conn *ldap.Conn, username string) (string, error) {
+	filter, err := cfg.RenderUserSearchFilter(username)
+	if err != nil {
+		return "", err
+	}
+	if filter == "" {
+		filter = fmt.Sprintf("(%s=%s)", cfg.UserAttr, ldap.EscapeFilter(username))
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		cfg.UserDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		[]string{},
+		nil,
+	))
+	if err != nil {
+		return "", fmt.Errorf("ldap search for user failed: %v", err)
+	}
+	if len(result.Entries) == 0 {
+		return "", fmt.Errorf("user %q not found", username)
+	}
+	if len(result.Entries) > 1 {
+		return "", fmt.Errorf("user %q is ambiguous (%d matches)", username, len(result.Entries))
+	}
+	return result.Entries[0].DN, nil
+}
+
+// findGroupNames searches for the groups userDN is a member of, rendering
+// groupfilter (and, when nested_group_search is set, resolving transitive
+// membership via the AD matching-rule OID) and reading the group's name out
+// of each match's groupattr attribute.
+func (b *backend) findGroupNames(cfg *ConfigEntry, conn *ldap.Conn, username, userDN string) ([]string, error) {
+	filter, err := cfg.RenderGroupFilter(username, userDN)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		cfg.GroupDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		[]string{cfg.GroupAttr},
+		nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("ldap search for groups failed: %v", err)
+	}
+
+	var names []string
+	for _, entry := range result.Entries {
+		name := entry.GetAttributeValue(cfg.GroupAttr)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// groupPolicies maps a list of LDAP group names to the union of policies
+// configured for each, via the "groups/<name>" endpoint. Groups with no
+// matching entry contribute no policies.
+func (b *backend) groupPolicies(storage logical.Storage, groupNames []string) ([]string, error) {
+	var policies []string
+	for _, name := range groupNames {
+		group, err := b.Group(storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if group == nil {
+			continue
+		}
+		policies = append(policies, group.Policies...)
+	}
+	return policies, nil
+}
+
+const pathLoginHelpSyn = `
+Log in with a username and password.
+`
+
+const pathLoginHelpDesc = `
+This endpoint authenticates using a username and LDAP password. The LDAP
+server is searched for the user's entry and the groups it belongs to; each
+group's configured policies (see the "groups/<name>" endpoint) are granted
+to the resulting token. When bind_mode is "sasl_external" or
+"sasl_external_then_search" the password is not checked; the user's entry
+only has to be found.
+`